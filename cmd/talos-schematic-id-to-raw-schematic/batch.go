@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/utkuozdemir/talos-schematic-id-to-raw-schematic/pkg/schematic"
+)
+
+// batchResult is one line of --batch mode's NDJSON output.
+type batchResult struct {
+	SchematicID string              `json:"schematic_id"`
+	Manifest    *schematic.Manifest `json:"manifest,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// runBatch resolves every schematic ID read from path using up to parallel
+// concurrent workers, writing one batchResult per line to stdout as NDJSON.
+func runBatch(ctx context.Context, extractor *schematic.Extractor, path string, parallel int) error {
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1, got %d", parallel)
+	}
+
+	ids, err := readSchematicIDs(path)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, parallel)
+		mu  sync.Mutex
+		enc = json.NewEncoder(os.Stdout)
+	)
+
+	for _, id := range ids {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := batchResult{SchematicID: id}
+
+			manifest, err := resolveSchematic(ctx, extractor, id, schematic.FetchOptions{})
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Manifest = manifest
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if encErr := enc.Encode(result); encErr != nil {
+				log.Printf("warning: failed to encode result for %q: %v", id, encErr)
+			}
+		}(id)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+// readSchematicIDs reads one schematic ID per line from path, or from stdin
+// when path is "-". Blank lines are skipped.
+func readSchematicIDs(path string) ([]string, error) {
+	f := os.Stdin
+
+	if path != "-" {
+		var err error
+
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %q: %w", path, err)
+		}
+
+		defer func() { _ = f.Close() }()
+	}
+
+	var ids []string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		id := strings.TrimSpace(scanner.Text())
+		if id == "" {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schematic ids from %q: %w", path, err)
+	}
+
+	return ids, nil
+}
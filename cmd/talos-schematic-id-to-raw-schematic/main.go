@@ -0,0 +1,102 @@
+// Command talos-schematic-id-to-raw-schematic resolves a Talos Image
+// Factory schematic ID to the raw schematic string baked into its
+// initramfs.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/utkuozdemir/talos-schematic-id-to-raw-schematic/pkg/schematic"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatalf("failed to run: %v", err)
+	}
+}
+
+func run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var (
+		jsonOutput    = flag.Bool("json", false, "print the full manifest (segments, records and extension layers) as JSON instead of just the raw schematic")
+		arch          = flag.String("arch", string(schematic.ArchAMD64), "target architecture (amd64, arm64)")
+		artifact      = flag.String("artifact", string(schematic.ArtifactInitramfs), "artifact to resolve the schematic from (initramfs, kernel, installer, metal-iso); only initramfs and installer contain extensions.yaml")
+		digest        = flag.String("digest", "", "expected sha256 digest of the fetched artifact; the download is rejected on mismatch")
+		cacheMaxBytes = flag.Int64("cache-max-bytes", 0, "evict least-recently-used cache entries once the cache exceeds this size in bytes (0 disables size-based eviction)")
+		cacheTTL      = flag.Duration("cache-ttl", 0, "evict cache entries unused for longer than this (0 disables TTL-based eviction)")
+		batchPath     = flag.String("batch", "", `read schematic IDs, one per line, from the given file (or "-" for stdin) and process them concurrently, emitting NDJSON to stdout`)
+		parallel      = flag.Int("parallel", 4, "number of schematic IDs to process concurrently in --batch mode")
+	)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] <schematic-id>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	extractor, err := schematic.New(schematic.Options{
+		Arch:          schematic.Arch(*arch),
+		Artifact:      schematic.Artifact(*artifact),
+		CacheMaxBytes: *cacheMaxBytes,
+		CacheTTL:      *cacheTTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *batchPath != "" {
+		return runBatch(ctx, extractor, *batchPath, *parallel)
+	}
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+
+		return fmt.Errorf("missing schematic id")
+	}
+
+	manifest, err := resolveSchematic(ctx, extractor, flag.Arg(0), schematic.FetchOptions{ExpectedDigest: *digest})
+	if err != nil {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(manifest)
+	}
+
+	log.Printf("raw schematic:\n%s", manifest.RawSchematic)
+	log.Printf("done, exiting")
+
+	return nil
+}
+
+// resolveSchematic fetches schematicID's configured artifact and extracts
+// its schematic manifest.
+func resolveSchematic(
+	ctx context.Context, extractor *schematic.Extractor, schematicID string, opts schematic.FetchOptions,
+) (*schematic.Manifest, error) {
+	artifact, err := extractor.FetchArtifact(ctx, schematicID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if closeErr := artifact.Close(); closeErr != nil {
+			log.Printf("warning: failed to close artifact: %v", closeErr)
+		}
+	}()
+
+	return extractor.ExtractSchematic(ctx, artifact)
+}
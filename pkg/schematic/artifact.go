@@ -0,0 +1,52 @@
+package schematic
+
+import "fmt"
+
+// Arch is a Talos artifact's target architecture.
+type Arch string
+
+// Supported architectures.
+const (
+	ArchAMD64 Arch = "amd64"
+	ArchARM64 Arch = "arm64"
+)
+
+// Artifact is a kind of artifact the Image Factory serves for a schematic.
+type Artifact string
+
+// Supported artifacts.
+const (
+	ArtifactInitramfs Artifact = "initramfs"
+	ArtifactKernel    Artifact = "kernel"
+	ArtifactInstaller Artifact = "installer"
+	ArtifactMetalISO  Artifact = "metal-iso"
+)
+
+// artifactFileExt is the file extension the Image Factory serves each
+// artifact as.
+var artifactFileExt = map[Artifact]string{
+	ArtifactInitramfs: "xz",
+	ArtifactKernel:    "xz",
+	ArtifactInstaller: "tar",
+	ArtifactMetalISO:  "iso",
+}
+
+// extensionsCapableArtifacts lists the artifacts that embed extensions.yaml
+// and can therefore be resolved to a raw schematic.
+var extensionsCapableArtifacts = map[Artifact]bool{
+	ArtifactInitramfs: true,
+	ArtifactInstaller: true,
+}
+
+// UnsupportedArtifactError is returned when Artifact does not embed
+// extensions.yaml and therefore cannot be resolved to a raw schematic.
+type UnsupportedArtifactError struct {
+	Artifact Artifact
+}
+
+func (e *UnsupportedArtifactError) Error() string {
+	return fmt.Sprintf(
+		"artifact %q does not contain extensions.yaml; only %q and %q do",
+		e.Artifact, ArtifactInitramfs, ArtifactInstaller,
+	)
+}
@@ -0,0 +1,36 @@
+package schematic
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewRejectsUnsupportedArtifact(t *testing.T) {
+	_, err := New(Options{Artifact: ArtifactKernel})
+
+	var unsupportedErr *UnsupportedArtifactError
+	if !errors.As(err, &unsupportedErr) {
+		t.Fatalf("got error %v, want *UnsupportedArtifactError", err)
+	}
+
+	if unsupportedErr.Artifact != ArtifactKernel {
+		t.Fatalf("got artifact %q, want %q", unsupportedErr.Artifact, ArtifactKernel)
+	}
+}
+
+func TestNewAcceptsExtensionsCapableArtifacts(t *testing.T) {
+	for _, artifact := range []Artifact{ArtifactInitramfs, ArtifactInstaller, ""} {
+		if _, err := New(Options{Artifact: artifact, CacheDir: t.TempDir()}); err != nil {
+			t.Errorf("New(Artifact: %q) returned unexpected error: %v", artifact, err)
+		}
+	}
+}
+
+func TestUnsupportedArtifactErrorMessage(t *testing.T) {
+	err := &UnsupportedArtifactError{Artifact: ArtifactMetalISO}
+
+	want := `artifact "metal-iso" does not contain extensions.yaml; only "initramfs" and "installer" do`
+	if got := err.Error(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,371 @@
+package schematic
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// cacheKey identifies one cached artifact by the parameters that produce it.
+type cacheKey struct {
+	talosVersion string
+	schematicID  string
+	arch         Arch
+	artifact     Artifact
+}
+
+// indexPath is the path of the symlink pointing at the content-addressed
+// blob cached for key.
+func (k cacheKey) indexPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "index", k.talosVersion, string(k.artifact), string(k.arch), k.schematicID)
+}
+
+func blobPath(cacheDir, digest string) string {
+	return filepath.Join(cacheDir, "sha256", digest)
+}
+
+// blobsLockPath is a lock covering the entire blob directory, as opposed to
+// the per-key locks taken on indexPath. Every access to a blob - resolving
+// an existing one, or downloading, evicting and opening a new one - holds
+// it (shared for reads, exclusive for the download-evict-open sequence), so
+// eviction triggered by one cache key can never remove a blob that another
+// key is in the middle of resolving, downloading or opening.
+func blobsLockPath(cacheDir string) string {
+	return filepath.Join(cacheDir, "sha256.lock")
+}
+
+// ensureArtifactCached downloads url into the content-addressable cache
+// under cacheDir (unless a copy is already cached for key), verifying
+// expectedDigest on the fly if one is given, and returns an open reader on
+// the cached blob. Concurrent callers for the same key coordinate via a
+// per-key file lock so two processes don't download the same artifact at
+// once.
+func ensureArtifactCached(
+	ctx context.Context, cacheDir string, key cacheKey, url, expectedDigest string, maxBytes int64, ttl time.Duration,
+) (io.ReadCloser, error) {
+	indexPath := key.indexPath(cacheDir)
+
+	if err := os.MkdirAll(filepath.Dir(indexPath), 0o700); err != nil {
+		return nil, fmt.Errorf("mkdir: %w", err)
+	}
+
+	lock := flock.New(indexPath + ".lock")
+
+	if err := lock.Lock(); err != nil {
+		return nil, fmt.Errorf("lock %q: %w", indexPath, err)
+	}
+
+	defer func() { _ = lock.Unlock() }()
+
+	if rc, ok, err := tryOpenCached(cacheDir, indexPath); err != nil {
+		return nil, err
+	} else if ok {
+		return rc, nil
+	}
+
+	return downloadEvictAndOpen(ctx, cacheDir, indexPath, url, expectedDigest, maxBytes, ttl)
+}
+
+// tryOpenCached resolves indexPath's cached blob, if any, bumps its mtime
+// and opens it, all while holding a shared lock on cacheDir's blobs lock.
+// Holding the lock across resolve-bump-open (not just the open) matters: a
+// concurrent downloadEvictAndOpen run, for any other key, takes that lock
+// exclusively, so it can't remove the blob in the window between it being
+// resolved and being opened.
+func tryOpenCached(cacheDir, indexPath string) (rc io.ReadCloser, ok bool, err error) {
+	blobsLock := flock.New(blobsLockPath(cacheDir))
+
+	if err = blobsLock.RLock(); err != nil {
+		return nil, false, fmt.Errorf("lock %q: %w", blobsLock.Path(), err)
+	}
+
+	defer func() { _ = blobsLock.Unlock() }()
+
+	path, ok := resolveCached(indexPath)
+	if !ok {
+		return nil, false, nil
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(path, now, now) // bump mtime so LRU eviction treats it as freshly used
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open %q: %w", path, err)
+	}
+
+	return f, true, nil
+}
+
+// downloadEvictAndOpen downloads url to a new blob, indexes it under
+// indexPath, evicts old blobs down to maxBytes/ttl (exempting the blob it
+// just wrote), and opens that blob - all while holding cacheDir's blobs
+// lock exclusively.
+//
+// The whole sequence has to run under one lock acquisition, not just the
+// evict-then-open tail of it: downloadToBlob's blob already exists on disk
+// (under its content-addressed name) the moment it returns, and if some
+// other key's download-evict-open runs in the gap before this call reaches
+// its own eviction, that other eviction only exempts *its* just-written
+// digest - it would see this blob as just another unreferenced, evictable
+// file and could remove it before this call ever gets a chance to exempt
+// it. Locking exclusively before the download begins closes that gap, at
+// the cost of serializing concurrent downloads against each other (e.g.
+// under --batch --parallel) whenever the cache is this contended.
+func downloadEvictAndOpen(
+	ctx context.Context, cacheDir, indexPath, url, expectedDigest string, maxBytes int64, ttl time.Duration,
+) (io.ReadCloser, error) {
+	blobsLock := flock.New(blobsLockPath(cacheDir))
+
+	if err := blobsLock.Lock(); err != nil {
+		return nil, fmt.Errorf("lock %q: %w", blobsLock.Path(), err)
+	}
+
+	defer func() { _ = blobsLock.Unlock() }()
+
+	digest, err := downloadToBlob(ctx, cacheDir, url, expectedDigest)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = relinkIndex(indexPath, blobPath(cacheDir, digest)); err != nil {
+		return nil, err
+	}
+
+	evicted, err := evictCache(filepath.Join(cacheDir, "sha256"), maxBytes, ttl, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if evicted {
+		if err = pruneDanglingIndexEntries(filepath.Join(cacheDir, "index")); err != nil {
+			return nil, err
+		}
+	}
+
+	path := blobPath(cacheDir, digest)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %q: %w", path, err)
+	}
+
+	return f, nil
+}
+
+// pruneDanglingIndexEntries walks indexDir and removes every symlink whose
+// blob target no longer exists, e.g. because evictCache removed it. Without
+// this, evicted blobs' symlinks would accumulate under indexDir forever.
+func pruneDanglingIndexEntries(indexDir string) error {
+	return filepath.WalkDir(indexDir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if d.Type()&os.ModeSymlink == 0 {
+			return nil
+		}
+
+		if _, ok := resolveCached(path); !ok {
+			_ = os.Remove(path)
+		}
+
+		return nil
+	})
+}
+
+// resolveCached returns the blob indexPath points to, if the symlink and its
+// target both still exist.
+func resolveCached(indexPath string) (string, bool) {
+	target, err := os.Readlink(indexPath)
+	if err != nil {
+		return "", false
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(indexPath), target)
+	}
+
+	if _, err = os.Stat(target); err != nil {
+		return "", false
+	}
+
+	return target, true
+}
+
+// downloadToBlob streams url to a content-addressed file under
+// cacheDir/sha256, hashing as it goes, and returns the resulting digest. If
+// expectedDigest is non-empty, the download is verified against it and the
+// blob is discarded on mismatch instead of being cached.
+func downloadToBlob(ctx context.Context, cacheDir, url, expectedDigest string) (string, error) {
+	blobDir := filepath.Join(cacheDir, "sha256")
+
+	if err := os.MkdirAll(blobDir, 0o700); err != nil {
+		return "", fmt.Errorf("mkdir: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(blobDir, ".partial-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp: %w", err)
+	}
+
+	defer func() { _ = os.Remove(tmp.Name()); _ = tmp.Close() }()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("new request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("download: %w", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download status %d", resp.StatusCode)
+	}
+
+	hasher := sha256.New()
+
+	if _, err = io.Copy(tmp, io.TeeReader(resp.Body, hasher)); err != nil {
+		return "", fmt.Errorf("write: %w", err)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+
+	if expectedDigest != "" {
+		if want := normalizeDigest(expectedDigest); !strings.EqualFold(want, digest) {
+			return "", fmt.Errorf("digest mismatch: expected %s, got %s", want, digest)
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return "", fmt.Errorf("fsync: %w", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return "", fmt.Errorf("close tmp: %w", err)
+	}
+
+	path := blobPath(cacheDir, digest)
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("rename: %w", err)
+	}
+
+	return digest, nil
+}
+
+// normalizeDigest strips an optional "sha256:" prefix off digest.
+func normalizeDigest(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+// relinkIndex points indexPath at blobPath via a relative symlink,
+// replacing any existing symlink.
+func relinkIndex(indexPath, blobPath string) error {
+	rel, err := filepath.Rel(filepath.Dir(indexPath), blobPath)
+	if err != nil {
+		rel = blobPath
+	}
+
+	_ = os.Remove(indexPath)
+
+	return os.Symlink(rel, indexPath)
+}
+
+// evictCache removes blobs older than ttl (if ttl > 0), then removes the
+// least-recently-used remaining blobs until blobDir's total size is at most
+// maxBytes (if maxBytes > 0). exemptName, if non-empty, names a blob (the one
+// the caller just wrote) that is never removed, even if it's the oldest or
+// it alone exceeds maxBytes - otherwise a maxBytes smaller than a single
+// artifact would have every fetch evict the blob it had just downloaded. It
+// reports whether any blob was removed, so callers can skip work that's
+// only needed after an eviction.
+func evictCache(blobDir string, maxBytes int64, ttl time.Duration, exemptName string) (evicted bool, err error) {
+	entries, err := os.ReadDir(blobDir)
+	if err != nil {
+		return false, fmt.Errorf("read cache dir: %w", err)
+	}
+
+	type blob struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+
+	var (
+		blobs []blob
+		total int64
+	)
+
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".partial-") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		b := blob{path: filepath.Join(blobDir, entry.Name()), size: info.Size(), modTime: info.ModTime()}
+
+		if entry.Name() == exemptName {
+			total += b.size
+
+			continue
+		}
+
+		if ttl > 0 && time.Since(b.modTime) > ttl {
+			_ = os.Remove(b.path)
+
+			evicted = true
+
+			continue
+		}
+
+		blobs = append(blobs, b)
+		total += b.size
+	}
+
+	if maxBytes <= 0 || total <= maxBytes {
+		return evicted, nil
+	}
+
+	sort.Slice(blobs, func(i, j int) bool { return blobs[i].modTime.Before(blobs[j].modTime) })
+
+	for _, b := range blobs {
+		if total <= maxBytes {
+			break
+		}
+
+		if err := os.Remove(b.path); err != nil {
+			continue
+		}
+
+		evicted = true
+		total -= b.size
+	}
+
+	return evicted, nil
+}
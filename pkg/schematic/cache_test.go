@@ -0,0 +1,298 @@
+package schematic
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDownloadToBlobSuccess(t *testing.T) {
+	const body = "artifact contents"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+
+	digest, err := downloadToBlob(context.Background(), cacheDir, srv.URL, "")
+	if err != nil {
+		t.Fatalf("downloadToBlob: %v", err)
+	}
+
+	got, err := os.ReadFile(blobPath(cacheDir, digest))
+	if err != nil {
+		t.Fatalf("read blob: %v", err)
+	}
+
+	if string(got) != body {
+		t.Fatalf("got blob content %q, want %q", got, body)
+	}
+}
+
+func TestDownloadToBlobDigestMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("artifact contents"))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+
+	wrongDigest := strings.Repeat("a", hex.EncodedLen(32))
+
+	if _, err := downloadToBlob(context.Background(), cacheDir, srv.URL, wrongDigest); err == nil {
+		t.Fatal("expected digest mismatch error, got nil")
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "sha256"))
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("expected no blobs to be persisted on digest mismatch, got %v", entries)
+	}
+}
+
+func TestEvictCacheTTL(t *testing.T) {
+	blobDir := t.TempDir()
+
+	writeBlob(t, blobDir, "old", "old content")
+	writeBlob(t, blobDir, "fresh", "fresh content")
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(blobDir, "old"), old, old); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	evicted, err := evictCache(blobDir, 0, time.Hour, "")
+	if err != nil {
+		t.Fatalf("evictCache: %v", err)
+	}
+
+	if !evicted {
+		t.Fatal("expected evictCache to report evicted=true")
+	}
+
+	assertBlobs(t, blobDir, "fresh")
+}
+
+func TestEvictCacheSizeLRU(t *testing.T) {
+	blobDir := t.TempDir()
+
+	writeBlob(t, blobDir, "oldest", "1234567890")
+	writeBlob(t, blobDir, "newest", "1234567890")
+
+	oldest := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(blobDir, "oldest"), oldest, oldest); err != nil {
+		t.Fatalf("chtimes: %v", err)
+	}
+
+	if _, err := evictCache(blobDir, 15, 0, ""); err != nil {
+		t.Fatalf("evictCache: %v", err)
+	}
+
+	assertBlobs(t, blobDir, "newest")
+}
+
+func TestEvictCacheExemptsJustWrittenBlob(t *testing.T) {
+	blobDir := t.TempDir()
+
+	writeBlob(t, blobDir, "fresh", "1234567890")
+
+	// maxBytes smaller than the single blob in the directory: without the
+	// exemption, evictCache would remove the blob the caller just wrote,
+	// leaving ensureArtifactCached unable to open the blob it just
+	// downloaded.
+	evicted, err := evictCache(blobDir, 5, 0, "fresh")
+	if err != nil {
+		t.Fatalf("evictCache: %v", err)
+	}
+
+	if evicted {
+		t.Fatal("expected evictCache to report evicted=false, exempted blob is the only one present")
+	}
+
+	assertBlobs(t, blobDir, "fresh")
+}
+
+func writeBlob(t *testing.T, blobDir, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(blobDir, name), []byte(content), 0o600); err != nil {
+		t.Fatalf("write blob %q: %v", name, err)
+	}
+}
+
+func assertBlobs(t *testing.T, blobDir string, want ...string) {
+	t.Helper()
+
+	entries, err := os.ReadDir(blobDir)
+	if err != nil {
+		t.Fatalf("read cache dir: %v", err)
+	}
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got blobs %v, want %v", got, want)
+	}
+
+	for i, name := range want {
+		if got[i] != name {
+			t.Fatalf("got blobs %v, want %v", got, want)
+		}
+	}
+}
+
+func TestEnsureArtifactCachedSurvivesSmallCacheMaxBytes(t *testing.T) {
+	const body = "artifact contents"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+	key := cacheKey{talosVersion: "v1.10.6", schematicID: "schematic", arch: ArchAMD64, artifact: ArtifactInitramfs}
+
+	// CacheMaxBytes smaller than the artifact itself: eviction must not
+	// remove the blob this call just downloaded out from under it.
+	rc, err := ensureArtifactCached(context.Background(), cacheDir, key, srv.URL, "", 1, 0)
+	if err != nil {
+		t.Fatalf("ensureArtifactCached: %v", err)
+	}
+
+	defer func() { _ = rc.Close() }()
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read artifact: %v", err)
+	}
+
+	if string(got) != body {
+		t.Fatalf("got artifact content %q, want %q", got, body)
+	}
+}
+
+// TestEnsureArtifactCachedConcurrentDownloadsDoNotRace fetches several
+// distinct schematic IDs concurrently against a CacheMaxBytes too small to
+// hold more than one artifact at a time, so every fetch triggers eviction
+// of every other fetch's blob. Without downloadEvictAndOpen's exclusive
+// lock spanning download-through-open, one goroutine's eviction can remove
+// a blob another goroutine just downloaded but hasn't opened yet.
+func TestEnsureArtifactCachedConcurrentDownloadsDoNotRace(t *testing.T) {
+	bodies := map[string]string{
+		"schematic-0": "artifact body for schematic 0 - padding padding",
+		"schematic-1": "artifact body for schematic 1 - padding padding",
+		"schematic-2": "artifact body for schematic 2 - padding padding",
+		"schematic-3": "artifact body for schematic 3 - padding padding",
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body, ok := bodies[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+			_, _ = w.Write([]byte(body))
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	cacheDir := t.TempDir()
+
+	var wg sync.WaitGroup
+
+	errs := make(chan error, len(bodies)*4)
+
+	for round := 0; round < 4; round++ {
+		for id, body := range bodies {
+			wg.Add(1)
+
+			go func(id, body string) {
+				defer wg.Done()
+
+				key := cacheKey{talosVersion: "v1.10.6", schematicID: id, arch: ArchAMD64, artifact: ArtifactInitramfs}
+
+				rc, err := ensureArtifactCached(context.Background(), cacheDir, key, srv.URL+"/"+id, "", 40, 0)
+				if err != nil {
+					errs <- fmt.Errorf("fetch %s: %w", id, err)
+
+					return
+				}
+
+				defer func() { _ = rc.Close() }()
+
+				got, err := io.ReadAll(rc)
+				if err != nil {
+					errs <- fmt.Errorf("read %s: %w", id, err)
+
+					return
+				}
+
+				if string(got) != body {
+					errs <- fmt.Errorf("fetch %s: got content %q, want %q", id, got, body)
+				}
+			}(id, body)
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestPruneDanglingIndexEntries(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	indexDir := filepath.Join(cacheDir, "index", "v1.10.6", "initramfs", "amd64")
+	if err := os.MkdirAll(indexDir, 0o700); err != nil {
+		t.Fatalf("mkdir index dir: %v", err)
+	}
+
+	live := blobPath(cacheDir, "livedigest")
+	if err := os.MkdirAll(filepath.Dir(live), 0o700); err != nil {
+		t.Fatalf("mkdir blob dir: %v", err)
+	}
+
+	if err := os.WriteFile(live, []byte("content"), 0o600); err != nil {
+		t.Fatalf("write blob: %v", err)
+	}
+
+	if err := relinkIndex(filepath.Join(indexDir, "live-schematic"), live); err != nil {
+		t.Fatalf("relink live: %v", err)
+	}
+
+	if err := relinkIndex(filepath.Join(indexDir, "dangling-schematic"), blobPath(cacheDir, "evicteddigest")); err != nil {
+		t.Fatalf("relink dangling: %v", err)
+	}
+
+	if err := pruneDanglingIndexEntries(filepath.Join(cacheDir, "index")); err != nil {
+		t.Fatalf("pruneDanglingIndexEntries: %v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(indexDir, "dangling-schematic")); !os.IsNotExist(err) {
+		t.Fatalf("expected dangling symlink to be removed, got err=%v", err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(indexDir, "live-schematic")); err != nil {
+		t.Fatalf("expected live symlink to remain, got err=%v", err)
+	}
+}
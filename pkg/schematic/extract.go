@@ -0,0 +1,235 @@
+package schematic
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/siderolabs/talos/pkg/machinery/extensions"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/ulikunitz/xz"
+	"gopkg.in/yaml.v3"
+)
+
+// Segment describes one decompressed cpio stream within a concatenated
+// initramfs.
+type Segment struct {
+	// Index is the segment's position within the initramfs, starting at 0.
+	Index int `json:"index"`
+	// Records lists every cpio record found in the segment, in order.
+	Records []Record `json:"records"`
+}
+
+// Record describes a single cpio record within a Segment.
+type Record struct {
+	// Name is the record's path within the cpio archive.
+	Name string `json:"name"`
+	// Size is the record's uncompressed content size, in bytes.
+	Size int64 `json:"size"`
+}
+
+// ExtensionLayer describes one entry of an extensions.yaml layers list.
+type ExtensionLayer struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Author    string `json:"author"`
+	Digest    string `json:"digest"`
+	ExtraInfo string `json:"extra_info"`
+}
+
+// walkInitramfs walks every segment of a (possibly multi-segment,
+// compressed) initramfs cpio archive to completion, recording every record
+// it finds and decoding every extensions.yaml it encounters along the way.
+func walkInitramfs(reader *bufio.Reader) ([]Segment, []ExtensionLayer, error) {
+	var (
+		segments []Segment
+		layers   []ExtensionLayer
+	)
+
+	for {
+		decReader, closeFunc, err := decompressingReadCloser(reader)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			return nil, nil, err
+		}
+
+		segment, segmentLayers, err := walkSegment(bufio.NewReader(decReader))
+
+		closeFunc()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		segment.Index = len(segments)
+		segments = append(segments, segment)
+		layers = append(layers, segmentLayers...)
+
+		if err = eatPadding(reader); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return segments, layers, nil
+}
+
+// walkSegment reads every cpio record from a single decompressed segment,
+// decoding extensions.yaml whenever it finds one.
+func walkSegment(reader *bufio.Reader) (Segment, []ExtensionLayer, error) {
+	var (
+		segment Segment
+		layers  []ExtensionLayer
+	)
+
+	d := &discarder{r: reader}
+	cpioReader := cpio.Newc.Reader(d)
+
+	for {
+		rec, err := cpioReader.ReadRecord()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return segment, layers, nil
+			}
+
+			return Segment{}, nil, err
+		}
+
+		segment.Records = append(segment.Records, Record{Name: rec.Name, Size: int64(rec.FileSize)})
+
+		if rec.Name == extensionsYAMLFileName {
+			recLayers, err := parseExtensionLayers(rec.ReaderAt)
+			if err != nil {
+				return Segment{}, nil, err
+			}
+
+			layers = append(layers, recLayers...)
+		}
+	}
+}
+
+func decompressingReadCloser(in *bufio.Reader) (rdr io.Reader, closeFunc func(), err error) {
+	magic, err := in.Peek(4)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte{0xfd, '7', 'z', 'X'}): // xz
+		var reader io.Reader
+
+		if reader, err = xz.NewReader(in); err != nil {
+			return nil, nil, err
+		}
+
+		return reader, func() {}, nil
+	case bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}): // zstd
+		var decoder *zstd.Decoder
+
+		if decoder, err = zstd.NewReader(in); err != nil {
+			return nil, nil, err
+		}
+
+		return decoder, decoder.Close, nil
+	default:
+		return in, func() {}, nil // return the original reader
+	}
+}
+
+func parseExtensionLayers(readerAt io.ReaderAt) ([]ExtensionLayer, error) {
+	sectionReader, ok := readerAt.(*io.SectionReader)
+	if !ok {
+		return nil, fmt.Errorf("unexpected ReaderAt type %T; want *io.SectionReader", readerAt)
+	}
+
+	var cfg extensions.Config
+
+	if err := yaml.NewDecoder(sectionReader).Decode(&cfg); err != nil {
+		return nil, err
+	}
+
+	layers := make([]ExtensionLayer, 0, len(cfg.Layers))
+
+	for _, l := range cfg.Layers {
+		layers = append(layers, ExtensionLayer{
+			Name:      l.Metadata.Name,
+			Version:   l.Metadata.Version,
+			Author:    l.Metadata.Author,
+			Digest:    imageDigest(l.Image),
+			ExtraInfo: l.Metadata.ExtraInfo,
+		})
+	}
+
+	return layers, nil
+}
+
+// imageDigest extracts the "sha256:..." digest suffix from an image
+// reference such as "ghcr.io/siderolabs/gvisor:30.0.0@sha256:abc...". It
+// returns an empty string if the reference carries no digest.
+func imageDigest(image string) string {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest
+	}
+
+	return ""
+}
+
+// discarder is used to implement ReadAt from a Reader
+// by reading, and discarding, data until the offset
+// is reached. it can only go forward. it is designed
+// for pipe-like files.
+type discarder struct {
+	r   io.Reader
+	pos int64
+}
+
+// ReadAt implements ReadAt for a discarder.
+// It is an error for the offset to be negative.
+func (r *discarder) ReadAt(p []byte, off int64) (int, error) {
+	if off-r.pos < 0 {
+		return 0, errors.New("negative seek on discarder not allowed")
+	}
+
+	if off != r.pos {
+		i, err := io.Copy(io.Discard, io.LimitReader(r.r, off-r.pos))
+		if err != nil || i != off-r.pos {
+			return 0, err
+		}
+
+		r.pos += i
+	}
+
+	n, err := io.ReadFull(r.r, p)
+	if err != nil {
+		return n, err
+	}
+
+	r.pos += int64(n)
+
+	return n, err
+}
+
+var _ io.ReaderAt = &discarder{}
+
+func eatPadding(in io.ByteScanner) error {
+	for {
+		b, err := in.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+
+		if b != 0 {
+			return in.UnreadByte()
+		}
+	}
+}
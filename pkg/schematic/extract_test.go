@@ -0,0 +1,171 @@
+package schematic
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/cpio"
+	"github.com/ulikunitz/xz"
+)
+
+const testExtensionsYAML = `layers:
+  - image: ghcr.io/siderolabs/gvisor:30.0.0@sha256:` + testGvisorDigest + `
+    metadata:
+      name: gvisor
+      version: 30.0.0
+      author: Sidero Labs
+      extraInfo: raw-schematic-gvisor
+`
+
+const testGvisorDigest = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+
+// buildCPIO writes a newc cpio archive containing name -> contents, in
+// order, followed by the trailer record.
+func buildCPIO(t *testing.T, files map[string]string, order []string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := cpio.Newc.Writer(&buf)
+
+	for _, name := range order {
+		if err := w.WriteRecord(cpio.StaticFile(name, files[name], 0o644)); err != nil {
+			t.Fatalf("write record %q: %v", name, err)
+		}
+	}
+
+	if err := cpio.WriteTrailer(w); err != nil {
+		t.Fatalf("write trailer: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func xzCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("new xz writer: %v", err)
+	}
+
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("xz write: %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("xz close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func zstdCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("new zstd writer: %v", err)
+	}
+
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("zstd write: %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("zstd close: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestWalkInitramfsSingleXZSegment(t *testing.T) {
+	archive := buildCPIO(t, map[string]string{
+		"init":                 "#!/bin/sh\n",
+		extensionsYAMLFileName: testExtensionsYAML,
+	}, []string{"init", extensionsYAMLFileName})
+
+	segments, layers, err := walkInitramfs(bufio.NewReader(bytes.NewReader(xzCompress(t, archive))))
+	if err != nil {
+		t.Fatalf("walkInitramfs: %v", err)
+	}
+
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+
+	if len(segments[0].Records) != 2 {
+		t.Fatalf("got %d records, want 2", len(segments[0].Records))
+	}
+
+	if len(layers) != 1 {
+		t.Fatalf("got %d extension layers, want 1", len(layers))
+	}
+
+	if layers[0].Name != "gvisor" || layers[0].Digest != "sha256:"+testGvisorDigest {
+		t.Fatalf("unexpected layer: %+v", layers[0])
+	}
+}
+
+func TestWalkInitramfsSingleZstdSegment(t *testing.T) {
+	archive := buildCPIO(t, map[string]string{
+		extensionsYAMLFileName: testExtensionsYAML,
+	}, []string{extensionsYAMLFileName})
+
+	segments, layers, err := walkInitramfs(bufio.NewReader(bytes.NewReader(zstdCompress(t, archive))))
+	if err != nil {
+		t.Fatalf("walkInitramfs: %v", err)
+	}
+
+	if len(segments) != 1 {
+		t.Fatalf("got %d segments, want 1", len(segments))
+	}
+
+	if len(layers) != 1 || layers[0].ExtraInfo != "raw-schematic-gvisor" {
+		t.Fatalf("unexpected layers: %+v", layers)
+	}
+}
+
+func TestWalkInitramfsMultiSegment(t *testing.T) {
+	// Uncompressed segments concatenated back to back, as the kernel's
+	// "early cpio" convention allows.
+	segment0 := buildCPIO(t, map[string]string{
+		"init": "#!/bin/sh\n",
+	}, []string{"init"})
+
+	segment1 := buildCPIO(t, map[string]string{
+		extensionsYAMLFileName: testExtensionsYAML,
+	}, []string{extensionsYAMLFileName})
+
+	var combined bytes.Buffer
+
+	combined.Write(segment0)
+	combined.Write(segment1)
+
+	segments, layers, err := walkInitramfs(bufio.NewReader(bytes.NewReader(combined.Bytes())))
+	if err != nil {
+		t.Fatalf("walkInitramfs: %v", err)
+	}
+
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segments))
+	}
+
+	if segments[0].Index != 0 || segments[1].Index != 1 {
+		t.Fatalf("unexpected segment indices: %d, %d", segments[0].Index, segments[1].Index)
+	}
+
+	if len(segments[0].Records) != 1 || len(segments[1].Records) != 1 {
+		t.Fatalf("unexpected record counts: %+v", segments)
+	}
+
+	if len(layers) != 1 {
+		t.Fatalf("got %d extension layers, want 1", len(layers))
+	}
+}
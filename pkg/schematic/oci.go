@@ -0,0 +1,111 @@
+package schematic
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	ecrlogin "github.com/awslabs/amazon-ecr-credential-helper/ecr-login"
+	acrcredhelper "github.com/chrismellard/docker-credential-acr-env/pkg/credhelper"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// initramfsLayerTitleMarker is the substring an installer image layer's
+// "org.opencontainers.image.title" annotation must contain to be considered
+// the initramfs layer.
+const initramfsLayerTitleMarker = "initramfs"
+
+// ociScheme marks a BaseURL as an OCI registry reference rather than an
+// Image Factory HTTP base URL, e.g. "oci://factory.talos.dev".
+const ociScheme = "oci://"
+
+// defaultKeychain merges the default Docker credential keychain with the
+// Google, AWS and Azure credential helpers, so pulls against authenticated
+// registries and pull-through mirrors work without extra configuration.
+func defaultKeychain() authn.Keychain {
+	return authn.NewMultiKeychain(
+		authn.DefaultKeychain,
+		google.Keychain,
+		authn.NewKeychainFromHelper(ecrlogin.NewECRHelper()),
+		authn.NewKeychainFromHelper(acrcredhelper.NewACRCredentialsHelper()),
+	)
+}
+
+// ociRef builds the installer image reference for a schematic from an
+// "oci://" base URL, e.g. "oci://factory.talos.dev" together with the
+// schematic ID and Talos version becomes
+// "factory.talos.dev/installer/<schematicID>:<version>".
+func ociRef(baseURL, schematicID, talosVersion string) string {
+	return fmt.Sprintf("%s/installer/%s:%s", strings.TrimPrefix(baseURL, ociScheme), schematicID, talosVersion)
+}
+
+// fetchInitramfsFromOCI pulls the installer image identified by ref - for
+// arch, resolving a multi-arch index to the matching platform manifest if
+// ref refers to one - and returns a reader over its initramfs layer.
+func fetchInitramfsFromOCI(ctx context.Context, keychain authn.Keychain, ref string, arch Arch) (io.ReadCloser, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parse image reference %q: %w", ref, err)
+	}
+
+	platform := v1.Platform{OS: "linux", Architecture: string(arch)}
+
+	img, err := remote.Image(
+		r, remote.WithContext(ctx), remote.WithAuthFromKeychain(keychain), remote.WithPlatform(platform),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("pull %s image %q: %w", platform.Architecture, ref, err)
+	}
+
+	layer, err := initramfsLayer(img, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("read initramfs layer of %q: %w", ref, err)
+	}
+
+	return rc, nil
+}
+
+// initramfsLayer identifies the layer of img that holds the initramfs, by
+// looking for the layer whose "org.opencontainers.image.title" annotation
+// names it as such. Falls back to the sole layer of a single-layer image,
+// since some installer image builds don't set the annotation.
+func initramfsLayer(img v1.Image, ref string) (v1.Layer, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("read manifest of %q: %w", ref, err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("list layers of %q: %w", ref, err)
+	}
+
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("image %q has no layers", ref)
+	}
+
+	if len(layers) == 1 {
+		return layers[0], nil
+	}
+
+	for i, desc := range manifest.Layers {
+		if strings.Contains(desc.Annotations["org.opencontainers.image.title"], initramfsLayerTitleMarker) {
+			return layers[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"could not identify the initramfs layer among %d layers of %q: none is titled %q",
+		len(layers), ref, initramfsLayerTitleMarker,
+	)
+}
@@ -0,0 +1,204 @@
+// Package schematic resolves a Talos Image Factory schematic ID into the raw
+// schematic (the extraInfo of the last layer in extensions.yaml) baked into
+// its initramfs. It
+// fetches the initramfs artifact - either over HTTP from the Image Factory or
+// as an installer image from an OCI registry - and walks its cpio archive to
+// find the embedded extensions configuration.
+package schematic
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+const (
+	// DefaultTalosVersion is used when Options.TalosVersion is empty.
+	DefaultTalosVersion = "v1.10.6"
+	// DefaultFactoryBaseURL is used when Options.BaseURL is empty.
+	DefaultFactoryBaseURL = "https://factory.talos.dev"
+	// DefaultCacheDirName is the directory created under the user's home
+	// directory when Options.CacheDir is empty.
+	DefaultCacheDirName = ".talos-schematic-id-to-raw-schematic-cache"
+
+	artifactURLTmpl        = "%s/image/%s/%s/%s-%s.%s" // baseURL, schematicID, talosVersion, artifact, arch, ext
+	extensionsYAMLFileName = "extensions.yaml"
+)
+
+// Options configures a new Extractor.
+type Options struct {
+	// TalosVersion is the Talos version the schematic was built for.
+	// Defaults to DefaultTalosVersion.
+	TalosVersion string
+	// BaseURL is the Image Factory base URL, or an "oci://" reference (e.g.
+	// "oci://factory.talos.dev") to pull installer images from an OCI
+	// registry instead. Defaults to DefaultFactoryBaseURL.
+	BaseURL string
+	// CacheDir is the directory downloaded artifacts are cached
+	// in. Defaults to a directory under the user's home directory. Unused
+	// when BaseURL is an OCI reference.
+	CacheDir string
+	// Keychain resolves registry credentials when BaseURL is an OCI
+	// reference. Defaults to a keychain covering Docker config, Google,
+	// AWS and Azure credential helpers.
+	Keychain authn.Keychain
+	// Arch is the target architecture to fetch artifacts for. Defaults to
+	// ArchAMD64.
+	Arch Arch
+	// Artifact is the kind of artifact to fetch. Only ArtifactInitramfs and
+	// ArtifactInstaller embed extensions.yaml. Defaults to
+	// ArtifactInitramfs.
+	Artifact Artifact
+	// CacheMaxBytes bounds the total size of the content-addressable cache
+	// under CacheDir. Once exceeded, least-recently-used entries are
+	// evicted on write. Zero disables size-based eviction.
+	CacheMaxBytes int64
+	// CacheTTL evicts cached entries that haven't been used in this long.
+	// Zero disables TTL-based eviction.
+	CacheTTL time.Duration
+}
+
+// Extractor fetches Talos artifacts from the Image Factory (or an OCI
+// registry) and extracts the raw schematic embedded in them.
+type Extractor struct {
+	talosVersion string
+	baseURL      string
+	cacheDir     string
+	keychain     authn.Keychain
+	arch         Arch
+	artifact     Artifact
+
+	cacheMaxBytes int64
+	cacheTTL      time.Duration
+}
+
+// New creates an Extractor from opts, applying defaults for any zero-valued
+// fields.
+func New(opts Options) (*Extractor, error) {
+	cacheDir := opts.CacheDir
+
+	if cacheDir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user home dir: %w", err)
+		}
+
+		cacheDir = filepath.Join(homeDir, DefaultCacheDirName)
+	}
+
+	talosVersion := opts.TalosVersion
+	if talosVersion == "" {
+		talosVersion = DefaultTalosVersion
+	}
+
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultFactoryBaseURL
+	}
+
+	keychain := opts.Keychain
+	if keychain == nil {
+		keychain = defaultKeychain()
+	}
+
+	arch := opts.Arch
+	if arch == "" {
+		arch = ArchAMD64
+	}
+
+	artifact := opts.Artifact
+	if artifact == "" {
+		artifact = ArtifactInitramfs
+	}
+
+	if !extensionsCapableArtifacts[artifact] {
+		return nil, &UnsupportedArtifactError{Artifact: artifact}
+	}
+
+	return &Extractor{
+		talosVersion:  talosVersion,
+		baseURL:       baseURL,
+		cacheDir:      cacheDir,
+		keychain:      keychain,
+		arch:          arch,
+		artifact:      artifact,
+		cacheMaxBytes: opts.CacheMaxBytes,
+		cacheTTL:      opts.CacheTTL,
+	}, nil
+}
+
+// FetchOptions configures a single FetchArtifact call.
+type FetchOptions struct {
+	// ExpectedDigest, if set, is the expected "sha256:<hex>" (or bare hex)
+	// digest of the fetched artifact. The download is verified against it
+	// on the fly; a mismatch fails the fetch instead of caching a corrupt
+	// artifact. It is ignored when BaseURL is an OCI reference.
+	ExpectedDigest string
+}
+
+// FetchArtifact downloads (or reuses a cached copy of) the configured
+// artifact for schematicID and returns a reader over it. The caller is
+// responsible for closing the returned ReadCloser.
+func (e *Extractor) FetchArtifact(ctx context.Context, schematicID string, opts FetchOptions) (io.ReadCloser, error) {
+	if strings.HasPrefix(e.baseURL, ociScheme) {
+		if e.artifact != ArtifactInstaller {
+			return nil, fmt.Errorf("oci sources only serve %q artifacts, got %q", ArtifactInstaller, e.artifact)
+		}
+
+		return fetchInitramfsFromOCI(ctx, e.keychain, ociRef(e.baseURL, schematicID, e.talosVersion), e.arch)
+	}
+
+	key := cacheKey{talosVersion: e.talosVersion, schematicID: schematicID, arch: e.arch, artifact: e.artifact}
+
+	return ensureArtifactCached(
+		ctx, e.cacheDir, key, e.artifactURL(schematicID), opts.ExpectedDigest, e.cacheMaxBytes, e.cacheTTL,
+	)
+}
+
+// Manifest is the outcome of walking an initramfs to completion: every
+// segment and cpio record it is made of, every extension layer found across
+// all of its extensions.yaml files, and the resulting raw schematic.
+type Manifest struct {
+	// Segments lists every decompressed cpio stream the initramfs is made
+	// of, in order.
+	Segments []Segment `json:"segments"`
+	// Extensions lists every extension layer found across every
+	// extensions.yaml encountered while walking Segments.
+	Extensions []ExtensionLayer `json:"extensions"`
+	// RawSchematic is the raw schematic string, as accepted by the Image
+	// Factory's schematic creation endpoint.
+	RawSchematic string `json:"raw_schematic"`
+}
+
+// ExtractSchematic walks a (possibly compressed, multi-segment) initramfs
+// cpio archive from r to completion and returns its Manifest.
+func (e *Extractor) ExtractSchematic(_ context.Context, r io.Reader) (*Manifest, error) {
+	segments, layers, err := walkInitramfs(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(layers) == 0 {
+		return nil, errors.New("no extensions.yaml found in initramfs")
+	}
+
+	return &Manifest{
+		Segments:     segments,
+		Extensions:   layers,
+		RawSchematic: layers[len(layers)-1].ExtraInfo,
+	}, nil
+}
+
+func (e *Extractor) artifactURL(schematicID string) string {
+	ext := artifactFileExt[e.artifact]
+
+	return fmt.Sprintf(artifactURLTmpl, e.baseURL, schematicID, e.talosVersion, e.artifact, e.arch, ext)
+}